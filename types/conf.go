@@ -18,8 +18,13 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 
 	"github.com/intel/multus-cni/logging"
+	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/containernetworking/cni/pkg/version"
@@ -28,10 +33,67 @@ import (
 const (
 	defaultCNIDir  = "/var/lib/cni/multus"
 	defaultConfDir = "/etc/cni/multus/net.d"
+
+	// minCheckCNIVersion is the first CNI spec version that introduced
+	// the CHECK command.
+	minCheckCNIVersion = "0.4.0"
+
+	// cachedResultKind identifies the on-disk format of a delegate's
+	// cached result, mirroring libcni's own result cache.
+	cachedResultKind = "cniCacheV1"
 )
 
+// probeDelegateCNIVersion execs the delegate plugin binary with the VERSION
+// command to discover the CNI spec versions it supports, for delegates whose
+// config doesn't carry its own "cniVersion". It returns the newest version
+// the plugin reports support for.
+func probeDelegateCNIVersion(pluginType, binDir string) (string, error) {
+	pluginPath, err := invoke.FindInPath(pluginType, []string{binDir})
+	if err != nil {
+		return "", fmt.Errorf("error finding delegate plugin %q in %q: %v", pluginType, binDir, err)
+	}
+
+	cmd := exec.Command(pluginPath)
+	cmd.Env = append(os.Environ(), "CNI_COMMAND=VERSION")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error probing delegate plugin %q version: %v", pluginType, err)
+	}
+
+	pluginInfo, err := version.PluginDecoder{}.Decode(out)
+	if err != nil {
+		return "", fmt.Errorf("error decoding delegate plugin %q version info: %v", pluginType, err)
+	}
+
+	supported := pluginInfo.SupportedVersions()
+	if len(supported) == 0 {
+		return "", fmt.Errorf("delegate plugin %q reports no supported CNI versions", pluginType)
+	}
+
+	// SupportedVersions isn't guaranteed to be sorted, so pick the
+	// highest version explicitly via proper version comparison.
+	best := supported[0]
+	for _, v := range supported[1:] {
+		ok, err := version.GreaterThanOrEqualTo(v, best)
+		if err != nil {
+			return "", fmt.Errorf("error comparing delegate plugin %q versions: %v", pluginType, err)
+		}
+		if ok {
+			best = v
+		}
+	}
+	return best, nil
+}
+
 // Convert raw CNI JSON into a DelegateNetConf structure
-func LoadDelegateNetConf(bytes []byte, ifnameRequest string) (*DelegateNetConf, error) {
+func LoadDelegateNetConf(bytes []byte, ifnameRequest string, binDir string) (*DelegateNetConf, error) {
+	var rawType struct {
+		Plugins []json.RawMessage `json:"plugins"`
+	}
+	if err := json.Unmarshal(bytes, &rawType); err == nil && len(rawType.Plugins) > 0 {
+		return loadDelegateNetConfList(bytes, rawType.Plugins, ifnameRequest, binDir)
+	}
+
 	delegateConf := &DelegateNetConf{}
 	if err := json.Unmarshal(bytes, delegateConf); err != nil {
 		return nil, fmt.Errorf("error unmarshalling delegate config: %v", err)
@@ -43,6 +105,17 @@ func LoadDelegateNetConf(bytes []byte, ifnameRequest string) (*DelegateNetConf,
 		return nil, fmt.Errorf("delegate must have the 'type' field")
 	}
 
+	// When the delegate's own config carries no "cniVersion", fall back to
+	// asking the plugin itself what it supports; the top-level config's
+	// cniVersion is not consulted here.
+	if delegateConf.CNIVersion == "" {
+		cniVersion, err := probeDelegateCNIVersion(delegateConf.Type, binDir)
+		if err != nil {
+			return nil, fmt.Errorf("error determining CNI version for delegate %q: %v", delegateConf.Type, err)
+		}
+		delegateConf.CNIVersion = cniVersion
+	}
+
 	if ifnameRequest != "" {
 		delegateConf.IfnameRequest = ifnameRequest
 	}
@@ -50,9 +123,103 @@ func LoadDelegateNetConf(bytes []byte, ifnameRequest string) (*DelegateNetConf,
 	return delegateConf, nil
 }
 
-func LoadNetworkStatus(r types.Result, netName string, defaultNet bool) (*NetworkStatus, error) {
+// setDefaultCNIVersion injects cniVersion into a plugin's raw JSON when the
+// plugin doesn't declare its own, so a conflist's negotiated version flows
+// down to each of its plugins instead of being probed independently.
+func setDefaultCNIVersion(bytes []byte, cniVersion string) ([]byte, error) {
+	if cniVersion == "" {
+		return bytes, nil
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(bytes, &raw); err != nil {
+		return nil, fmt.Errorf("error unmarshalling plugin config: %v", err)
+	}
+	if _, ok := raw["cniVersion"]; ok {
+		return bytes, nil
+	}
+
+	raw["cniVersion"] = cniVersion
+	newBytes, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling plugin config: %v", err)
+	}
+	return newBytes, nil
+}
+
+// loadDelegateNetConfList parses a delegate conflist ("plugins": [...]) into
+// a DelegateNetConfList, loading each plugin entry as its own DelegateNetConf
+// in the order they appear.
+func loadDelegateNetConfList(bytes []byte, rawPlugins []json.RawMessage, ifnameRequest string, binDir string) (*DelegateNetConf, error) {
+	confList := &DelegateNetConfList{}
+	if err := json.Unmarshal(bytes, confList); err != nil {
+		return nil, fmt.Errorf("error unmarshalling delegate conflist: %v", err)
+	}
+	confList.Bytes = bytes
+
+	if confList.Name == "" {
+		return nil, fmt.Errorf("delegate conflist must have the 'name' field")
+	}
+
+	for idx, rawPlugin := range rawPlugins {
+		// A conflist's plugins don't carry their own "cniVersion" per
+		// the CNI spec - it's declared once for the whole list - so
+		// inherit it here rather than letting LoadDelegateNetConf
+		// probe the plugin binary for a version it doesn't actually
+		// negotiate independently.
+		pluginBytes, err := setDefaultCNIVersion(rawPlugin, confList.CNIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("error preparing plugin %d of delegate conflist %q: %v", idx, confList.Name, err)
+		}
+
+		pluginDelegate, err := LoadDelegateNetConf(pluginBytes, "", binDir)
+		if err != nil {
+			return nil, fmt.Errorf("error loading plugin %d of delegate conflist %q: %v", idx, confList.Name, err)
+		}
+		confList.Delegates = append(confList.Delegates, pluginDelegate)
+	}
+
+	delegateConf := &DelegateNetConf{}
+	delegateConf.Name = confList.Name
+	delegateConf.CNIVersion = confList.CNIVersion
+	delegateConf.Bytes = bytes
+	delegateConf.ConfList = confList
+
+	if ifnameRequest != "" {
+		delegateConf.IfnameRequest = ifnameRequest
+	}
+
+	return delegateConf, nil
+}
+
+// LoadNetworkStatus converts a delegate's raw CNI result into a
+// NetworkStatus, negotiating the result's schema version against the
+// delegate's own CNIVersion rather than assuming the latest spec version;
+// only when delegate is nil or has no CNIVersion is
+// current.ImplementedSpecVersion used.
+// rawResult is the delegate's original, unparsed result JSON as the plugin
+// returned it; it's used to recover fields (mtu, deviceInfo) that aren't
+// part of the typed current.Result schema and would otherwise be lost the
+// moment r was parsed. Pass nil if unavailable - those fields are then
+// left unset.
+func LoadNetworkStatus(r types.Result, netName string, defaultNet bool, delegate *DelegateNetConf, rawResult []byte) (*NetworkStatus, error) {
+	cniVersion := current.ImplementedSpecVersion
+	if delegate != nil && delegate.CNIVersion != "" {
+		cniVersion = delegate.CNIVersion
+	}
+
+	resultBytes, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling delegate result: %v", err)
+	}
+
+	versionedResult, err := version.NewResult(cniVersion, resultBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing delegate result as CNI %s: %v", cniVersion, err)
+	}
+
 	// Convert whatever the IPAM result was into the current Result type
-	result, err := current.NewResultFromResult(r)
+	result, err := current.NewResultFromResult(versionedResult)
 	if err != nil {
 		return nil, fmt.Errorf("error convert the type.Result to current.Result: %v", err)
 	}
@@ -61,26 +228,63 @@ func LoadNetworkStatus(r types.Result, netName string, defaultNet bool) (*Networ
 	netstatus.Name = netName
 	netstatus.Default = defaultNet
 
-	for _, ifs := range result.Interfaces {
+	podIfIndex := -1
+	for i, ifs := range result.Interfaces {
 		//Only pod interfaces can have sandbox information
 		if ifs.Sandbox != "" {
+			podIfIndex = i
 			netstatus.Interface = ifs.Name
 			netstatus.Mac = ifs.Mac
+			netstatus.Sandbox = ifs.Sandbox
 		}
 	}
 
 	for _, ipconfig := range result.IPs {
-		if ipconfig.Version == "4" && ipconfig.Address.IP.To4() != nil {
-			netstatus.IPs = append(netstatus.IPs, ipconfig.Address.IP.String())
+		// Skip an IP/gateway that the result assigns to an interface
+		// other than the pod interface; IPs with no interface index
+		// are kept.
+		if podIfIndex != -1 && ipconfig.Interface != nil && *ipconfig.Interface != podIfIndex {
+			continue
 		}
 
-		if ipconfig.Version == "6" && ipconfig.Address.IP.To16() != nil {
+		// CNI 1.0.0 dropped the per-IP "version" field; derive the
+		// address family from the IP itself instead.
+		if ipconfig.Address.IP.To4() != nil {
 			netstatus.IPs = append(netstatus.IPs, ipconfig.Address.IP.String())
+		} else if ipconfig.Address.IP.To16() != nil {
+			netstatus.IPs = append(netstatus.IPs, ipconfig.Address.IP.String())
+		}
+
+		if ipconfig.Gateway != nil {
+			netstatus.Gateway = append(netstatus.Gateway, ipconfig.Gateway.String())
 		}
 	}
 
+	// The CNI spec doesn't attribute individual routes to a specific
+	// interface, so for a conflist/multi-interface result every route
+	// in the result is attached to this attachment; there's no schema
+	// field to filter on.
+	netstatus.Routes = result.Routes
 	netstatus.DNS = result.DNS
 
+	// Mtu and DeviceInfo aren't part of the current.Result schema; some
+	// delegates (e.g. SR-IOV-aware plugins) attach them as extensions on
+	// the interface entry. By the time r is parsed into a typed Result
+	// those extensions are gone, so they must come from the delegate's
+	// original, unparsed result bytes instead.
+	if podIfIndex != -1 && len(rawResult) > 0 {
+		var rawInterfaces struct {
+			Interfaces []struct {
+				Mtu        int         `json:"mtu,omitempty"`
+				DeviceInfo *DeviceInfo `json:"deviceInfo,omitempty"`
+			} `json:"interfaces,omitempty"`
+		}
+		if err := json.Unmarshal(rawResult, &rawInterfaces); err == nil && podIfIndex < len(rawInterfaces.Interfaces) {
+			netstatus.Mtu = rawInterfaces.Interfaces[podIfIndex].Mtu
+			netstatus.DeviceInfo = rawInterfaces.Interfaces[podIfIndex].DeviceInfo
+		}
+	}
+
 	return netstatus, nil
 
 }
@@ -138,7 +342,7 @@ func LoadNetConf(bytes []byte) (*NetConf, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error marshalling delegate %d config: %v", idx, err)
 		}
-		delegateConf, err := LoadDelegateNetConf(bytes, "")
+		delegateConf, err := LoadDelegateNetConf(bytes, "", netconf.BinDir)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load delegate %d config: %v", idx, err)
 		}
@@ -163,3 +367,133 @@ func (n *NetConf) AddNetworkStatus(newNetStatus []*NetworkStatus) error {
 	n.NetStatus = append(n.NetStatus, newNetStatus...)
 	return nil
 }
+
+// AddRuntimeConfig records a CNI capability argument (e.g. "portMappings",
+// "bandwidth", "ips", "mac", "infinibandGUID", "dns") destined for the
+// delegate at delegateIdx, and injects it into that delegate's raw Bytes as
+// a "runtimeConfig" block - but only if the delegate's own config advertised
+// support for that capability. Delegates that don't advertise it are left
+// untouched, the same way libcni only forwards RuntimeConf.CapabilityArgs a
+// plugin asked for.
+func (n *NetConf) AddRuntimeConfig(delegateIdx int, key string, value interface{}) error {
+	if delegateIdx < 0 || delegateIdx >= len(n.Delegates) {
+		return fmt.Errorf("invalid delegate index %d", delegateIdx)
+	}
+	delegate := n.Delegates[delegateIdx]
+
+	if !delegate.Capabilities[key] {
+		return nil
+	}
+
+	if n.RuntimeConfig == nil {
+		n.RuntimeConfig = map[string]interface{}{}
+	}
+	n.RuntimeConfig[key] = value
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(delegate.Bytes, &raw); err != nil {
+		return fmt.Errorf("error unmarshalling delegate %d config: %v", delegateIdx, err)
+	}
+
+	runtimeConfig, ok := raw["runtimeConfig"].(map[string]interface{})
+	if !ok {
+		runtimeConfig = map[string]interface{}{}
+	}
+	runtimeConfig[key] = value
+	raw["runtimeConfig"] = runtimeConfig
+
+	newBytes, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("error marshalling delegate %d config: %v", delegateIdx, err)
+	}
+	delegate.Bytes = newBytes
+
+	return nil
+}
+
+// SupportsCheck reports whether every delegate's CNI version is new
+// enough to support the CHECK command, the way a future cmdCheck needs to
+// confirm before iterating delegates.
+func (n *NetConf) SupportsCheck() bool {
+	for _, delegate := range n.Delegates {
+		ok, err := version.GreaterThanOrEqualTo(delegate.CNIVersion, minCheckCNIVersion)
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedResult is the on-disk shape of a delegate's cached CNI result.
+type cachedResult struct {
+	Kind        string          `json:"kind"`
+	ContainerID string          `json:"containerId"`
+	IfName      string          `json:"ifName"`
+	NetworkName string          `json:"networkName"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// delegateCacheFilePath returns the path libcni-style caching would use
+// for a delegate's result, keyed by network name, containerID and ifname.
+func delegateCacheFilePath(cniDir, netName, containerID, ifName string) string {
+	return filepath.Join(cniDir, "results", fmt.Sprintf("%s-%s-%s.json", netName, containerID, ifName))
+}
+
+// SaveDelegateCache persists delegate's CachedResult to disk under CNIDir,
+// keyed by netName+containerID+ifName, so a later CHECK can load it without
+// re-invoking ADD.
+func (n *NetConf) SaveDelegateCache(delegate *DelegateNetConf, netName, containerID, ifName string) error {
+	if delegate.CachedResult == nil {
+		return nil
+	}
+
+	resultBytes, err := json.Marshal(delegate.CachedResult)
+	if err != nil {
+		return fmt.Errorf("error marshalling delegate result for cache: %v", err)
+	}
+
+	cache := &cachedResult{
+		Kind:        cachedResultKind,
+		ContainerID: containerID,
+		IfName:      ifName,
+		NetworkName: netName,
+		Result:      resultBytes,
+	}
+	cacheBytes, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("error marshalling delegate cache: %v", err)
+	}
+
+	path := delegateCacheFilePath(n.CNIDir, netName, containerID, ifName)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating cache dir: %v", err)
+	}
+	if err := ioutil.WriteFile(path, cacheBytes, 0600); err != nil {
+		return fmt.Errorf("error writing delegate cache: %v", err)
+	}
+
+	return nil
+}
+
+// LoadDelegateCache reads back a delegate's result previously written by
+// SaveDelegateCache, populating delegate.CachedResult for CHECK.
+func (n *NetConf) LoadDelegateCache(delegate *DelegateNetConf, netName, containerID, ifName string) error {
+	path := delegateCacheFilePath(n.CNIDir, netName, containerID, ifName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading delegate cache: %v", err)
+	}
+
+	cache := &cachedResult{}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return fmt.Errorf("error unmarshalling delegate cache: %v", err)
+	}
+
+	result := &current.Result{}
+	if err := json.Unmarshal(cache.Result, result); err != nil {
+		return fmt.Errorf("error unmarshalling cached delegate result: %v", err)
+	}
+	delegate.CachedResult = result
+
+	return nil
+}
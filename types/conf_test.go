@@ -0,0 +1,139 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+func TestLoadDelegateNetConfList(t *testing.T) {
+	confListBytes := []byte(`{
+		"cniVersion": "0.4.0",
+		"name": "mynet",
+		"plugins": [
+			{"type": "bridge"},
+			{"type": "tuning"}
+		]
+	}`)
+
+	delegate, err := LoadDelegateNetConf(confListBytes, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error loading conflist delegate: %v", err)
+	}
+	if delegate.ConfList == nil {
+		t.Fatalf("expected delegate to carry a ConfList")
+	}
+	if delegate.Name != "mynet" {
+		t.Errorf("expected delegate name %q, got %q", "mynet", delegate.Name)
+	}
+	if len(delegate.ConfList.Delegates) != 2 {
+		t.Fatalf("expected 2 chained plugin delegates, got %d", len(delegate.ConfList.Delegates))
+	}
+	for i, plugin := range delegate.ConfList.Delegates {
+		if plugin.CNIVersion != "0.4.0" {
+			t.Errorf("plugin %d: expected inherited cniVersion %q, got %q", i, "0.4.0", plugin.CNIVersion)
+		}
+	}
+}
+
+func TestLoadDelegateNetConfSingle(t *testing.T) {
+	bytes := []byte(`{"cniVersion": "0.4.0", "type": "bridge"}`)
+	delegate, err := LoadDelegateNetConf(bytes, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if delegate.ConfList != nil {
+		t.Errorf("expected single-plugin delegate to have a nil ConfList")
+	}
+	if delegate.Type != "bridge" {
+		t.Errorf("expected type %q, got %q", "bridge", delegate.Type)
+	}
+}
+
+func TestAddRuntimeConfigCapabilityGating(t *testing.T) {
+	delegateBytes := []byte(`{"cniVersion": "0.4.0", "type": "portmap", "capabilities": {"portMappings": true}}`)
+	delegate, err := LoadDelegateNetConf(delegateBytes, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error loading delegate: %v", err)
+	}
+	netconf := &NetConf{Delegates: []*DelegateNetConf{delegate}}
+
+	portMappings := []map[string]interface{}{{"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}}
+	if err := netconf.AddRuntimeConfig(0, "portMappings", portMappings); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(delegate.Bytes, &raw); err != nil {
+		t.Fatalf("unexpected error unmarshalling delegate bytes: %v", err)
+	}
+	runtimeConfig, ok := raw["runtimeConfig"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected runtimeConfig to be injected into delegate bytes")
+	}
+	if _, ok := runtimeConfig["portMappings"]; !ok {
+		t.Errorf("expected portMappings to be present in injected runtimeConfig")
+	}
+
+	// A capability the delegate didn't advertise must not be injected.
+	if err := netconf.AddRuntimeConfig(0, "bandwidth", map[string]interface{}{"ingressRate": 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(delegate.Bytes, &raw); err != nil {
+		t.Fatalf("unexpected error unmarshalling delegate bytes: %v", err)
+	}
+	if runtimeConfig, ok := raw["runtimeConfig"].(map[string]interface{}); ok {
+		if _, ok := runtimeConfig["bandwidth"]; ok {
+			t.Errorf("expected bandwidth not to be injected since delegate didn't advertise it")
+		}
+	}
+}
+
+func TestDelegateCacheRoundTrip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "multus-cache-test")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	netconf := &NetConf{CNIDir: tmpDir}
+	delegate := &DelegateNetConf{
+		CachedResult: &current.Result{
+			CNIVersion: "0.4.0",
+			Interfaces: []*current.Interface{{Name: "eth0", Sandbox: "/proc/1/ns/net"}},
+		},
+	}
+
+	if err := netconf.SaveDelegateCache(delegate, "mynet", "abc123", "eth0"); err != nil {
+		t.Fatalf("unexpected error saving delegate cache: %v", err)
+	}
+
+	loaded := &DelegateNetConf{}
+	if err := netconf.LoadDelegateCache(loaded, "mynet", "abc123", "eth0"); err != nil {
+		t.Fatalf("unexpected error loading delegate cache: %v", err)
+	}
+	if loaded.CachedResult == nil {
+		t.Fatalf("expected CachedResult to be populated")
+	}
+	if len(loaded.CachedResult.Interfaces) != 1 || loaded.CachedResult.Interfaces[0].Name != "eth0" {
+		t.Errorf("expected cached result to round-trip interface eth0, got %+v", loaded.CachedResult.Interfaces)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+// NetConf for cni config file written in json
+type NetConf struct {
+	types.NetConf
+	RawPrevResult map[string]interface{} `json:"prevResult,omitempty"`
+	PrevResult    current.Result         `json:"-"`
+
+	ConfDir    string `json:"confDir"`
+	CNIDir     string `json:"cniDir"`
+	BinDir     string `json:"binDir"`
+	Kubeconfig string `json:"kubeconfig"`
+
+	Delegates    []*DelegateNetConf       `json:"-"`
+	RawDelegates []map[string]interface{} `json:"delegates"`
+
+	LogFile  string `json:"logFile,omitempty"`
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// RuntimeConfig carries CNI capability arguments (portMappings,
+	// bandwidth, ips, mac, infinibandGUID, dns, ...) destined for
+	// delegates that advertise support for them; see AddRuntimeConfig.
+	RuntimeConfig map[string]interface{} `json:"runtimeConfig,omitempty"`
+
+	// NetStatus is the list of network attachment statuses already
+	// applied to this pod, used to populate the
+	// k8s.v1.cni.cncf.io/networks-status annotation.
+	NetStatus []*NetworkStatus `json:"-"`
+}
+
+// DelegateNetConf holds a delegate plugin's configuration, both as raw
+// bytes suitable for handing to the plugin binary and as the parsed
+// fields multus itself needs.
+type DelegateNetConf struct {
+	types.NetConf
+
+	// Raw JSON bytes of the delegate config, used to invoke the plugin
+	Bytes []byte `json:"-"`
+
+	// IfnameRequest is the interface name requested for this delegate,
+	// if any, otherwise multus picks one.
+	IfnameRequest string `json:"-"`
+
+	// MasterPlugin is true for the first delegate, which determines the
+	// pod's primary network status.
+	MasterPlugin bool `json:"-"`
+
+	// ConfList is set instead of relying on the embedded types.NetConf
+	// above when this delegate's raw config is a CNI conflist
+	// ("plugins": [...]) rather than a single plugin config.
+	ConfList *DelegateNetConfList `json:"-"`
+
+	// CachedResult is this delegate's ADD result, loaded from (or
+	// destined for) the on-disk cache in CNIDir, so a later CHECK can
+	// be run against it without re-invoking ADD.
+	CachedResult *current.Result `json:"-"`
+}
+
+// DelegateNetConfList holds a delegate configured as a CNI conflist (a
+// "plugins" array) rather than a single plugin config, along with the
+// per-plugin DelegateNetConf entries chained together in invocation order.
+// On ADD the plugins are invoked in order, each receiving the previous
+// plugin's result as its prevResult; on DEL they must be invoked in
+// reverse, mirroring libcni's AddNetworkList/DelNetworkList.
+type DelegateNetConfList struct {
+	types.NetConfList
+
+	// Raw JSON bytes of the delegate conflist config
+	Bytes []byte `json:"-"`
+
+	// Delegates holds the per-plugin delegate configs within this
+	// conflist, in the order they must be invoked.
+	Delegates []*DelegateNetConf `json:"-"`
+}
+
+// NetworkStatus is the status of a particular network attached to a pod,
+// mirroring the shape published in the
+// k8s.v1.cni.cncf.io/networks-status annotation.
+type NetworkStatus struct {
+	Name      string         `json:"name"`
+	Interface string         `json:"interface,omitempty"`
+	IPs       []string       `json:"ips,omitempty"`
+	Mac       string         `json:"mac,omitempty"`
+	Default   bool           `json:"default,omitempty"`
+	DNS       types.DNS      `json:"dns,omitempty"`
+	Gateway   []string       `json:"gateway,omitempty"`
+	Routes    []*types.Route `json:"routes,omitempty"`
+	Sandbox   string         `json:"sandbox,omitempty"`
+	Mtu       int            `json:"mtu,omitempty"`
+
+	// DeviceInfo is set when the delegate result attaches device-level
+	// details (e.g. an SR-IOV VF) to the pod interface.
+	DeviceInfo *DeviceInfo `json:"device-info,omitempty"`
+}
+
+// DeviceInfo captures device-level details for a pod interface, such as
+// the PCI address and VF index of an SR-IOV device, for operators
+// consuming the networks-status annotation.
+type DeviceInfo struct {
+	Type    string     `json:"type,omitempty"`
+	Version string     `json:"version,omitempty"`
+	Pci     *PciDevice `json:"pci,omitempty"`
+}
+
+// PciDevice identifies a PCI device backing a pod interface.
+type PciDevice struct {
+	PciAddress string `json:"pci-address,omitempty"`
+	VFID       int    `json:"vf-id,omitempty"`
+}